@@ -0,0 +1,126 @@
+// Package hls provides helpers for synthesizing HLS media playlists and
+// probing input media so that transcoded segments line up on keyframe
+// boundaries.
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Segment describes one HLS media segment in terms of the source timeline.
+type Segment struct {
+	Index    int
+	Start    float64
+	Duration float64
+}
+
+// Probe runs ffprobe against input and returns its duration (in seconds)
+// and the presentation timestamps of its keyframes.
+func Probe(input string) (duration float64, keyframes []float64, err error) {
+	duration, err = probeDuration(input)
+	if err != nil {
+		return 0, nil, err
+	}
+	keyframes, err = probeKeyframes(input)
+	if err != nil {
+		return 0, nil, err
+	}
+	return duration, keyframes, nil
+}
+
+// ProbeDuration runs ffprobe against input and returns its duration, in
+// seconds, without also probing keyframes. Useful for callers that only
+// need the duration, e.g. to derive transcode progress percentage.
+func ProbeDuration(input string) (float64, error) {
+	return probeDuration(input)
+}
+
+func probeDuration(input string) (float64, error) {
+	cmd := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", input)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("hls: probe duration: %v", err)
+	}
+	return strconv.ParseFloat(strings.TrimSpace(out.String()), 64)
+}
+
+func probeKeyframes(input string) ([]float64, error) {
+	cmd := exec.Command("ffprobe", "-select_streams", "v", "-show_frames", "-skip_frame", "nokey",
+		"-show_entries", "frame=pkt_pts_time", "-of", "csv=p=0", input)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("hls: probe keyframes: %v", err)
+	}
+
+	var keyframes []float64
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		pts, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			continue
+		}
+		keyframes = append(keyframes, pts)
+	}
+	return keyframes, nil
+}
+
+// BuildSegments splits duration into segments aligned to the given keyframe
+// timestamps, so every segment boundary lands on an I-frame.
+func BuildSegments(keyframes []float64, duration float64) []Segment {
+	var bounds = append([]float64{0}, keyframes...)
+	if len(bounds) == 0 || bounds[len(bounds)-1] < duration {
+		bounds = append(bounds, duration)
+	}
+
+	segments := make([]Segment, 0, len(bounds)-1)
+	for i := 0; i < len(bounds)-1; i++ {
+		start := bounds[i]
+		end := bounds[i+1]
+		if end <= start {
+			continue
+		}
+		segments = append(segments, Segment{
+			Index:    len(segments),
+			Start:    start,
+			Duration: end - start,
+		})
+	}
+	return segments
+}
+
+// WriteMediaPlaylist writes an HLS media playlist (m3u8) describing segments.
+// last, when true, appends the EXT-X-ENDLIST tag.
+func WriteMediaPlaylist(segments []Segment, last bool) string {
+	target := 0.0
+	for _, s := range segments {
+		if s.Duration > target {
+			target = s.Duration
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(target+1))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	for _, s := range segments {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", s.Duration)
+		fmt.Fprintf(&b, "segment%d.ts\n", s.Index)
+	}
+	if last {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.String()
+}
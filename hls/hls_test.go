@@ -0,0 +1,54 @@
+package hls
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildSegmentsAlignsToKeyframes(t *testing.T) {
+	segments := BuildSegments([]float64{2, 5}, 8)
+
+	want := []Segment{
+		{Index: 0, Start: 0, Duration: 2},
+		{Index: 1, Start: 2, Duration: 3},
+		{Index: 2, Start: 5, Duration: 3},
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("got %d segments, want %d: %+v", len(segments), len(want), segments)
+	}
+	for i, seg := range segments {
+		if seg != want[i] {
+			t.Errorf("segment %d = %+v, want %+v", i, seg, want[i])
+		}
+	}
+}
+
+func TestBuildSegmentsNoTrailingKeyframe(t *testing.T) {
+	// Keyframes already cover the full duration: no extra zero-length
+	// segment should be appended for the end of the input.
+	segments := BuildSegments([]float64{4}, 4)
+
+	if len(segments) != 1 {
+		t.Fatalf("got %d segments, want 1: %+v", len(segments), segments)
+	}
+	if segments[0] != (Segment{Index: 0, Start: 0, Duration: 4}) {
+		t.Errorf("segment 0 = %+v", segments[0])
+	}
+}
+
+func TestWriteMediaPlaylistEndList(t *testing.T) {
+	segments := BuildSegments([]float64{2}, 5)
+
+	playlist := WriteMediaPlaylist(segments, true)
+	if !strings.Contains(playlist, "#EXT-X-ENDLIST") {
+		t.Errorf("last=true playlist missing #EXT-X-ENDLIST:\n%s", playlist)
+	}
+	if !strings.Contains(playlist, "segment0.ts") || !strings.Contains(playlist, "segment1.ts") {
+		t.Errorf("playlist missing expected segment entries:\n%s", playlist)
+	}
+
+	live := WriteMediaPlaylist(segments, false)
+	if strings.Contains(live, "#EXT-X-ENDLIST") {
+		t.Errorf("last=false playlist should omit #EXT-X-ENDLIST:\n%s", live)
+	}
+}
@@ -0,0 +1,81 @@
+// Package httpapi exposes go_trans job progress over HTTP, so external
+// services can watch a transcode live instead of polling ListTask.
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	trans "github.com/tangs-drm/go-trans"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// ProgressHandler upgrades the request to a WebSocket and streams
+// ProgressEvent values for the task named by the "taskId" query parameter
+// until a write to the client fails or an event reports Percent >= 100. A
+// task that ends below 100 percent (for example one that fails) does not
+// end the stream.
+func ProgressHandler(tm *trans.TransManage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskId := r.URL.Query().Get("taskId")
+		if taskId == "" {
+			http.Error(w, "missing taskId", http.StatusBadRequest)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		events, unsubscribe := tm.Subscribe(taskId)
+		defer unsubscribe()
+
+		for ev := range events {
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+			if ev.Percent >= 100 {
+				return
+			}
+		}
+	}
+}
+
+// ProgressJSONHandler is a plain HTTP fallback for clients that can't speak
+// WebSocket: it subscribes for the length of the request and returns an
+// event only if one is already waiting on that new subscription, else 204.
+// Events published before the request are not kept, so an earlier most
+// recent event is not returned.
+func ProgressJSONHandler(tm *trans.TransManage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskId := r.URL.Query().Get("taskId")
+		if taskId == "" {
+			http.Error(w, "missing taskId", http.StatusBadRequest)
+			return
+		}
+
+		events, unsubscribe := tm.Subscribe(taskId)
+		defer unsubscribe()
+
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(ev)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Package hwaccel detects which hardware video encoders the local ffmpeg
+// binary actually supports and exposes an ordered fallback chain so callers
+// can ask for "auto" and get the best encoder this host can use.
+package hwaccel
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Encoder identifies an ffmpeg encoder selection.
+type Encoder string
+
+const (
+	Auto        Encoder = "auto"
+	Copy        Encoder = "copy"
+	LibX264     Encoder = "libx264"
+	H264VAAPI   Encoder = "h264_vaapi"
+	H264NVENC   Encoder = "h264_nvenc"
+	H264QSV     Encoder = "h264_qsv"
+	H264ToolBox Encoder = "h264_videotoolbox"
+	H264V4L2M2M Encoder = "h264_v4l2m2m"
+)
+
+// defaultPriority is the order hardware encoders are preferred in, with the
+// software encoder last as the universal fallback.
+var defaultPriority = []Encoder{
+	H264NVENC, H264QSV, H264VAAPI, H264ToolBox, H264V4L2M2M, LibX264,
+}
+
+// Detect parses `ffmpeg -hide_banner -encoders` for the encoders this repo
+// knows how to drive, runs a sanity transcode against each, and returns the
+// subset that actually work, ordered by defaultPriority.
+func Detect() ([]Encoder, error) {
+	listed, err := listEncoders()
+	if err != nil {
+		return nil, err
+	}
+
+	var available []Encoder
+	for _, enc := range defaultPriority {
+		if !listed[enc] {
+			continue
+		}
+		if err := SanityCheck(enc); err != nil {
+			continue
+		}
+		available = append(available, enc)
+	}
+	return available, nil
+}
+
+func listEncoders() (map[Encoder]bool, error) {
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-encoders")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("hwaccel: list encoders: %v", err)
+	}
+
+	listed := map[Encoder]bool{LibX264: false, Copy: true}
+	for _, line := range strings.Split(out.String(), "\n") {
+		for _, enc := range defaultPriority {
+			if strings.Contains(line, string(enc)) {
+				listed[enc] = true
+			}
+		}
+	}
+	return listed, nil
+}
+
+// SanityCheck runs a tiny end-to-end transcode of a synthetic test pattern
+// through enc, returning an error if the encoder can't actually be driven
+// (missing driver, no device node, etc.) despite being listed.
+func SanityCheck(enc Encoder) error {
+	args := []string{"-hide_banner", "-loglevel", "error", "-f", "lavfi", "-i", "testsrc=duration=1:size=64x64:rate=1"}
+	args = append(args, encodeArgs(enc)...)
+	args = append(args, "-f", "null", "-")
+
+	cmd := exec.Command("ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("hwaccel: sanity check %v: %v: %v", enc, err, stderr.String())
+	}
+	return nil
+}
+
+// encodeArgs returns the ffmpeg flags needed to select enc, including any
+// -hwaccel / device / pixel format plumbing it requires.
+func encodeArgs(enc Encoder) []string {
+	switch enc {
+	case H264VAAPI:
+		return []string{"-vaapi_device", "/dev/dri/renderD128", "-vf", "format=nv12,hwupload", "-c:v", "h264_vaapi"}
+	case H264NVENC:
+		return []string{"-hwaccel", "cuda", "-c:v", "h264_nvenc"}
+	case H264QSV:
+		return []string{"-hwaccel", "qsv", "-c:v", "h264_qsv"}
+	case H264ToolBox:
+		return []string{"-c:v", "h264_videotoolbox"}
+	case H264V4L2M2M:
+		return []string{"-c:v", "h264_v4l2m2m"}
+	case Copy:
+		return []string{"-c:v", "copy"}
+	default:
+		return []string{"-c:v", "libx264"}
+	}
+}
+
+// Chain is an ordered fallback list an encode can walk through: try the
+// first entry, and on failure demote to the next.
+type Chain []Encoder
+
+// Resolve builds the fallback chain for a requested encoder. "auto" expands
+// to the full detected chain; any other request is tried alone first, with
+// libx264 appended as the universal last resort (unless the request was
+// already libx264, in which case the chain is just that one entry).
+func Resolve(requested Encoder, available []Encoder) Chain {
+	if requested == "" || requested == Auto {
+		chain := append(Chain{}, available...)
+		if len(chain) == 0 {
+			chain = Chain{LibX264}
+		}
+		return chain
+	}
+
+	chain := Chain{requested}
+	if requested != LibX264 {
+		chain = append(chain, LibX264)
+	}
+	return chain
+}
+
+// EncodeArgs returns the ffmpeg flags needed to select enc, including any
+// -hwaccel / device / pixel format plumbing it requires. Plugins building
+// their ffmpeg command line should call this once the chain has settled on
+// an encoder.
+func EncodeArgs(enc Encoder) []string {
+	return encodeArgs(enc)
+}
+
+// Demotion records that a stream fell back from one encoder to another.
+type Demotion struct {
+	From Encoder
+	To   Encoder
+	Err  error
+}
@@ -0,0 +1,44 @@
+package hwaccel
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveAuto(t *testing.T) {
+	available := []Encoder{H264NVENC, H264QSV}
+
+	got := Resolve(Auto, available)
+	want := Chain{H264NVENC, H264QSV}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve(Auto, %v) = %v, want %v", available, got, want)
+	}
+}
+
+func TestResolveAutoNoneAvailable(t *testing.T) {
+	got := Resolve(Auto, nil)
+	want := Chain{LibX264}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve(Auto, nil) = %v, want %v", got, want)
+	}
+}
+
+func TestResolveSpecificRequestTriesAloneThenLibX264(t *testing.T) {
+	available := []Encoder{H264NVENC, H264QSV, H264VAAPI}
+
+	got := Resolve(H264VAAPI, available)
+	want := Chain{H264VAAPI, LibX264}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve(H264VAAPI, %v) = %v, want %v", available, got, want)
+	}
+}
+
+func TestResolveRequestingLibX264DoesNotRepeatIt(t *testing.T) {
+	available := []Encoder{H264NVENC, LibX264}
+
+	got := Resolve(LibX264, available)
+	want := Chain{LibX264}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve(LibX264, %v) = %v, want %v", available, got, want)
+	}
+}
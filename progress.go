@@ -0,0 +1,218 @@
+package go_trans
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tangs-drm/go-trans/hls"
+)
+
+// ProgressEvent is a single point-in-time snapshot of a running task,
+// parsed from ffmpeg's -progress key=value stream.
+type ProgressEvent struct {
+	TaskId  string
+	Percent float64
+	Fps     float64
+	Speed   float64
+	ETA     time.Duration
+	Bitrate string
+}
+
+// ProgressSubscriptionBuffer is how many undelivered ProgressEvents a
+// subscriber channel holds before new events are dropped.
+var ProgressSubscriptionBuffer = 32
+
+type progressSub struct {
+	ch      chan ProgressEvent
+	dropped int
+}
+
+// Subscribe returns a channel of ProgressEvent for taskId and an unsubscribe
+// func that must be called once the caller is done reading. The channel is
+// bounded; if the reader falls behind, events are dropped rather than
+// blocking the publisher.
+func (tm *TransManage) Subscribe(taskId string) (<-chan ProgressEvent, func()) {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+
+	sub := &progressSub{ch: make(chan ProgressEvent, ProgressSubscriptionBuffer)}
+	tm.progressSubs[taskId] = append(tm.progressSubs[taskId], sub)
+
+	unsubscribe := func() {
+		tm.lock.Lock()
+		defer tm.lock.Unlock()
+		subs := tm.progressSubs[taskId]
+		for i, s := range subs {
+			if s == sub {
+				tm.progressSubs[taskId] = append(subs[:i], subs[i+1:]...)
+				close(sub.ch)
+				break
+			}
+		}
+	}
+	return sub.ch, unsubscribe
+}
+
+// publishProgress fans ev out to every subscriber of ev.TaskId, dropping
+// rather than blocking when a subscriber's buffer is full.
+func (tm *TransManage) publishProgress(ev ProgressEvent) {
+	tm.lock.Lock()
+	subs := tm.progressSubs[ev.TaskId]
+	tm.lock.Unlock()
+
+	for _, sub := range subs {
+		select {
+		case sub.ch <- ev:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// Process starts (or continues) progress reporting for the given task ids,
+// polling each task's plugin and publishing ProgressEvent values to any
+// Subscribe callers until the task finishes.
+func (tm *TransManage) Process(ids []string) {
+	for _, id := range ids {
+		tm.lock.Lock()
+		var task *Task
+		for _, t := range tm.Tasks {
+			if t.Id == id {
+				task = t
+				break
+			}
+		}
+		tm.lock.Unlock()
+
+		if task == nil {
+			continue
+		}
+		go tm.watchProgress(task)
+	}
+}
+
+// ProgressPipeProvider is an optional TransPlugin extension for plugins
+// that drive ffmpeg directly: it exposes the `-progress pipe:2` stream so
+// watchProgress can parse it with ParseProgressPipe instead of polling
+// Process(). Plugins that don't implement it fall back to polling.
+type ProgressPipeProvider interface {
+	ProgressPipe() (r io.Reader, durationSeconds float64, err error)
+}
+
+func (tm *TransManage) watchProgress(task *Task) {
+	if provider, ok := task.Plugin.(ProgressPipeProvider); ok {
+		tm.watchProgressPipe(task, provider)
+		return
+	}
+
+	durationMs := 0.0
+	if duration, err := hls.ProbeDuration(task.Input); err == nil {
+		durationMs = duration * 1000
+	} else {
+		log.Printf("watchProgress %v: probe duration: %v", task.Id, err)
+	}
+
+	for {
+		raw, err := task.Plugin.Process()
+		if err != nil {
+			return
+		}
+		raw["_duration_ms"] = durationMs
+
+		ev := progressEventFromFields(task.Id, raw)
+		tm.publishProgress(ev)
+
+		if raw["progress"] == "end" {
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func (tm *TransManage) watchProgressPipe(task *Task, provider ProgressPipeProvider) {
+	r, duration, err := provider.ProgressPipe()
+	if err != nil {
+		return
+	}
+	for ev := range ParseProgressPipe(task.Id, r, duration) {
+		tm.publishProgress(ev)
+	}
+}
+
+func progressEventFromFields(taskId string, fields map[string]interface{}) ProgressEvent {
+	ev := ProgressEvent{TaskId: taskId}
+	ev.Fps = toFloat(fields["fps"])
+	ev.Speed = parseSpeed(fields["speed"])
+	if bitrate, ok := fields["bitrate"].(string); ok {
+		ev.Bitrate = bitrate
+	}
+
+	outTimeMs := toFloat(fields["out_time_ms"])
+	duration := toFloat(fields["_duration_ms"])
+	if duration > 0 {
+		ev.Percent = outTimeMs / duration * 100
+		if ev.Speed > 0 {
+			remaining := (duration - outTimeMs) / 1000
+			ev.ETA = time.Duration(remaining / ev.Speed * float64(time.Second))
+		}
+	}
+	return ev
+}
+
+// parseSpeed parses ffmpeg's -progress "speed" field, which is rendered as
+// e.g. "1.02x" rather than a bare number.
+func parseSpeed(v interface{}) float64 {
+	s, ok := v.(string)
+	if !ok {
+		return toFloat(v)
+	}
+	return toFloat(strings.TrimSuffix(strings.TrimSpace(s), "x"))
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int:
+		return float64(n)
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	default:
+		return 0
+	}
+}
+
+// ParseProgressPipe reads ffmpeg's `-progress pipe:2 -nostats` key=value
+// stream from r and emits a ProgressEvent per progress=... block, until r
+// is closed. durationSeconds is the probed input duration, used to derive
+// Percent and ETA.
+func ParseProgressPipe(taskId string, r io.Reader, durationSeconds float64) <-chan ProgressEvent {
+	out := make(chan ProgressEvent)
+	go func() {
+		defer close(out)
+
+		fields := map[string]interface{}{"_duration_ms": durationSeconds * 1000}
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			fields[key] = strings.TrimSpace(value)
+
+			if key == "progress" {
+				out <- progressEventFromFields(taskId, fields)
+				if value == "end" {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,62 @@
+package go_trans
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSpeed(t *testing.T) {
+	cases := []struct {
+		in   interface{}
+		want float64
+	}{
+		{"1.02x", 1.02},
+		{" 0.5x ", 0.5},
+		{2.0, 2.0},
+		{"not-a-number", 0},
+	}
+	for _, c := range cases {
+		if got := parseSpeed(c.in); got != c.want {
+			t.Errorf("parseSpeed(%v) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestProgressEventFromFields(t *testing.T) {
+	fields := map[string]interface{}{
+		"fps":          "25",
+		"speed":        "2x",
+		"bitrate":      "1200kbits/s",
+		"out_time_ms":  "30000",
+		"_duration_ms": "60000",
+	}
+
+	ev := progressEventFromFields("task-1", fields)
+
+	if ev.TaskId != "task-1" {
+		t.Errorf("TaskId = %v, want task-1", ev.TaskId)
+	}
+	if ev.Fps != 25 {
+		t.Errorf("Fps = %v, want 25", ev.Fps)
+	}
+	if ev.Speed != 2 {
+		t.Errorf("Speed = %v, want 2", ev.Speed)
+	}
+	if ev.Bitrate != "1200kbits/s" {
+		t.Errorf("Bitrate = %v, want 1200kbits/s", ev.Bitrate)
+	}
+	if ev.Percent != 50 {
+		t.Errorf("Percent = %v, want 50", ev.Percent)
+	}
+	if ev.ETA != 15*time.Second {
+		t.Errorf("ETA = %v, want 15s", ev.ETA)
+	}
+}
+
+func TestProgressEventFromFieldsNoDuration(t *testing.T) {
+	ev := progressEventFromFields("task-1", map[string]interface{}{"out_time_ms": "1000"})
+
+	if ev.Percent != 0 || ev.ETA != 0 {
+		t.Errorf("expected zero Percent/ETA without duration, got Percent=%v ETA=%v", ev.Percent, ev.ETA)
+	}
+}
@@ -0,0 +1,218 @@
+package go_trans
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/tangs-drm/go-trans/hls"
+	"github.com/tangs-drm/go-trans/util"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// SegmentOptions controls how AddSegmentedTask splits, farms out and
+// reassembles a large input.
+type SegmentOptions struct {
+	// MinSegmentSeconds merges keyframe-aligned splits shorter than this
+	// into their neighbour, so very dense keyframe intervals don't produce
+	// one ffmpeg invocation per frame.
+	MinSegmentSeconds float64
+
+	// WorkerCount overrides MaxRunningNum for this task's worker pool. Zero
+	// means "use tm.MaxRunningNum".
+	WorkerCount int
+
+	// KeepOnFailure leaves the temp dir and partial segments in place for
+	// debugging instead of cleaning them up.
+	KeepOnFailure bool
+}
+
+// DefaultSegmentOptions is used by AddSegmentedTask when opts is the zero
+// value; its MinSegmentSeconds also replaces a zero or negative
+// opts.MinSegmentSeconds, keeping the caller's other fields.
+var DefaultSegmentOptions = SegmentOptions{MinSegmentSeconds: 5}
+
+// AddSegmentedTask splits input at keyframe boundaries, transcodes the
+// segments in parallel across a worker pool, and concatenates the results
+// into output. It's meant for large, CPU-bound jobs (e.g. H.264->H.265)
+// where splitting the work across cores measurably beats one long-running
+// ffmpeg process.
+func (tm *TransManage) AddSegmentedTask(input, output string, opts SegmentOptions) (Task, error) {
+	tm.lock.Lock()
+	inputExt := filepath.Ext(input)
+	outputExt := filepath.Ext(output)
+	if "" == inputExt {
+		tm.lock.Unlock()
+		return Task{}, util.NewError("input is invalid: %v", input)
+	}
+	if "" == outputExt {
+		tm.lock.Unlock()
+		return Task{}, util.NewError("output is invalid: %v", output)
+	}
+	plugin := tm.TransPlugin[inputExt]
+	if plugin == nil {
+		tm.lock.Unlock()
+		return Task{}, util.NewError("unsupported format: %v", inputExt)
+	}
+
+	if opts.MinSegmentSeconds <= 0 {
+		opts.MinSegmentSeconds = DefaultSegmentOptions.MinSegmentSeconds
+	}
+
+	task := &Task{
+		Id:     util.UUID(),
+		Input:  input,
+		Output: output,
+		Plugin: plugin,
+		Status: TASK_QUEUED,
+	}
+	if tm.Store != nil {
+		tm.Store.Save(TaskRecord{Id: task.Id, Input: input, Output: output, Format: inputExt, Status: TASK_QUEUED})
+	}
+	tm.Tasks = append(tm.Tasks, task)
+	tm.lock.Unlock()
+
+	go tm.runSegmented(task, opts)
+
+	return *task, nil
+}
+
+func (tm *TransManage) runSegmented(task *Task, opts SegmentOptions) {
+	task.Status = TASK_RUNNING
+	if tm.Store != nil {
+		tm.Store.UpdateStatus(task.Id, TASK_RUNNING, 0)
+	}
+
+	err := tm.execSegmented(task, opts)
+
+	status := TASK_SUCCESS
+	if err != nil {
+		status = TASK_FAILED
+	}
+	task.Status = status
+	if tm.Store != nil {
+		tm.Store.UpdateStatus(task.Id, status, 0)
+	}
+
+	tm.CallBack(Call{
+		Code:         0,
+		ErrorMessage: err,
+		Task:         *task,
+	})
+}
+
+func (tm *TransManage) execSegmented(task *Task, opts SegmentOptions) (err error) {
+	rangeExecer, ok := task.Plugin.(RangeExecer)
+	if !ok {
+		return util.NewError("segmented: plugin %v does not implement RangeExecer", task.Plugin.Type())
+	}
+
+	duration, keyframes, err := hls.Probe(task.Input)
+	if err != nil {
+		return util.NewError("segmented: probe %v: %v", task.Input, err)
+	}
+
+	segments := mergeShortSegments(hls.BuildSegments(keyframes, duration), opts.MinSegmentSeconds)
+
+	tmpDir, err := ioutil.TempDir("", "go-trans-segmented-")
+	if err != nil {
+		return util.NewError("segmented: temp dir: %v", err)
+	}
+	// KeepOnFailure only means "leave the temp dir behind when the run
+	// failed" — a successful run always cleans up after itself.
+	defer func() {
+		if !opts.KeepOnFailure || err == nil {
+			os.RemoveAll(tmpDir)
+		}
+	}()
+
+	workers := opts.WorkerCount
+	if workers <= 0 {
+		workers = tm.MaxRunningNum
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+
+	group, ctx := errgroup.WithContext(context.Background())
+	sem := make(chan struct{}, workers)
+	segmentFiles := make([]string, len(segments))
+
+	for i, seg := range segments {
+		i, seg := i, seg
+		group.Go(func() error {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			data, err := rangeExecer.ExecRange(task.Input, seg.Start, seg.Duration, task.Args)
+			if err != nil {
+				return util.NewError("segmented: segment %v: %v", seg.Index, err)
+			}
+
+			path := filepath.Join(tmpDir, fmt.Sprintf("segment-%05d.ts", i))
+			if err := ioutil.WriteFile(path, data, 0644); err != nil {
+				return util.NewError("segmented: write segment %v: %v", seg.Index, err)
+			}
+			segmentFiles[i] = path
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return err
+	}
+
+	return concatSegments(tmpDir, segmentFiles, task.Output)
+}
+
+// mergeShortSegments grows each keyframe-aligned segment shorter than
+// minSeconds by folding the segments after it into it until it reaches
+// minSeconds, so dense keyframe intervals don't explode into a huge number
+// of tiny ffmpeg invocations. A short final segment is left as it is.
+func mergeShortSegments(segments []hls.Segment, minSeconds float64) []hls.Segment {
+	if len(segments) == 0 {
+		return segments
+	}
+
+	merged := []hls.Segment{segments[0]}
+	for _, seg := range segments[1:] {
+		last := &merged[len(merged)-1]
+		if last.Duration < minSeconds {
+			last.Duration += seg.Duration
+			continue
+		}
+		merged = append(merged, seg)
+	}
+	for i := range merged {
+		merged[i].Index = i
+	}
+	return merged
+}
+
+// concatSegments writes an ffmpeg concat demuxer list file and joins
+// segmentFiles into output, preserving codecs (no re-encode).
+func concatSegments(tmpDir string, segmentFiles []string, output string) error {
+	listPath := filepath.Join(tmpDir, "concat.txt")
+	var list string
+	for _, f := range segmentFiles {
+		list += fmt.Sprintf("file '%s'\n", f)
+	}
+	if err := ioutil.WriteFile(listPath, []byte(list), 0644); err != nil {
+		return util.NewError("segmented: write concat list: %v", err)
+	}
+
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "error", "-y",
+		"-f", "concat", "-safe", "0", "-i", listPath, "-c", "copy", output)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return util.NewError("segmented: concat: %v: %s", err, out)
+	}
+	return nil
+}
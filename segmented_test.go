@@ -0,0 +1,45 @@
+package go_trans
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tangs-drm/go-trans/hls"
+)
+
+func TestMergeShortSegmentsFoldsConsecutiveShortOnes(t *testing.T) {
+	segments := []hls.Segment{
+		{Index: 0, Start: 0, Duration: 3},
+		{Index: 1, Start: 3, Duration: 3},
+		{Index: 2, Start: 6, Duration: 3},
+		{Index: 3, Start: 9, Duration: 3},
+	}
+
+	got := mergeShortSegments(segments, 5)
+
+	want := []hls.Segment{
+		{Index: 0, Start: 0, Duration: 6},
+		{Index: 1, Start: 6, Duration: 6},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeShortSegments() = %+v, want %+v", got, want)
+	}
+}
+
+func TestMergeShortSegmentsEmpty(t *testing.T) {
+	if got := mergeShortSegments(nil, 5); len(got) != 0 {
+		t.Errorf("mergeShortSegments(nil) = %+v, want empty", got)
+	}
+}
+
+func TestMergeShortSegmentsNoneShort(t *testing.T) {
+	segments := []hls.Segment{
+		{Index: 0, Start: 0, Duration: 6},
+		{Index: 1, Start: 6, Duration: 7},
+	}
+
+	got := mergeShortSegments(segments, 5)
+	if !reflect.DeepEqual(got, segments) {
+		t.Errorf("mergeShortSegments() = %+v, want unchanged %+v", got, segments)
+	}
+}
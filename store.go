@@ -0,0 +1,119 @@
+package go_trans
+
+import (
+	"time"
+
+	"github.com/tangs-drm/go-trans/util"
+)
+
+// Task status values used by TaskStore records, in addition to TASK_RUNNING.
+const (
+	TASK_QUEUED   = "TASK_QUEUED"
+	TASK_SUCCESS  = "TASK_SUCCESS"
+	TASK_FAILED   = "TASK_FAILED"
+	TASK_CANCELED = "TASK_CANCELED"
+	TASK_RETRYING = "TASK_RETRYING"
+)
+
+// TaskRecord is the persisted form of a Task: enough to rebuild the queue
+// and resume in-flight work after a restart.
+type TaskRecord struct {
+	Id       string
+	Input    string
+	Output   string
+	Format   string
+	Args     map[string]interface{}
+	Status   string
+	Attempts int
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TaskStore persists the transcoding queue so it survives a process
+// restart. Implementations live in store/sqlite and store/redis.
+type TaskStore interface {
+	// Save inserts or updates a task record.
+	Save(record TaskRecord) error
+
+	// Load returns the record for id.
+	Load(id string) (TaskRecord, error)
+
+	// UpdateStatus updates only the status (and attempt count) of id.
+	UpdateStatus(id, status string, attempts int) error
+
+	// List returns up to limit records starting at skip, and the total
+	// record count. limit < 0 returns every record.
+	List(limit, skip int) ([]TaskRecord, int, error)
+
+	// Delete removes a task record.
+	Delete(id string) error
+
+	// Claim returns every record left in status (e.g. after an unclean
+	// shutdown) so the caller can requeue them.
+	Claim(status string) ([]TaskRecord, error)
+}
+
+// SetStore attaches a TaskStore so the queue survives restarts. Call this
+// before RunTask so Resume can find any tasks left running.
+func (tm *TransManage) SetStore(store TaskStore) {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+	tm.Store = store
+}
+
+// Resume claims any tasks left in TASK_RUNNING by a previous, uncleanly
+// stopped process, marks them for retry and re-queues them.
+func (tm *TransManage) Resume() error {
+	if tm.Store == nil {
+		return nil
+	}
+
+	orphaned, err := tm.Store.Claim(TASK_RUNNING)
+	if err != nil {
+		return util.NewError("resume: claim running tasks: %v", err)
+	}
+
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+	for _, record := range orphaned {
+		plugin := tm.TransPlugin[record.Format]
+		if plugin == nil {
+			tm.Store.UpdateStatus(record.Id, TASK_FAILED, record.Attempts)
+			continue
+		}
+		task := &Task{
+			Id:     record.Id,
+			Input:  record.Input,
+			Output: record.Output,
+			Args:   record.Args,
+			Plugin: plugin,
+			Status: TASK_RETRYING,
+		}
+		tm.attempts[record.Id] = record.Attempts
+		tm.Tasks = append(tm.Tasks, task)
+		tm.Store.UpdateStatus(record.Id, TASK_RETRYING, record.Attempts)
+		tm.addSign <- 1
+	}
+	return nil
+}
+
+// shouldRetry reports whether a task that just failed with err, having
+// already used attempts of tryTimes total attempts, should be retried.
+// tryTimes counts the first attempt, so tryTimes <= 1 never retries.
+func shouldRetry(err error, canceled bool, attempts, tryTimes int) bool {
+	return err != nil && !canceled && attempts+1 < tryTimes
+}
+
+// backoff returns the delay before attempt (0-indexed) is retried: 1s, 2s,
+// 4s, ... capped at one minute.
+func backoff(attempt int) time.Duration {
+	d := time.Second
+	for i := 0; i < attempt; i++ {
+		d *= 2
+		if d >= time.Minute {
+			return time.Minute
+		}
+	}
+	return d
+}
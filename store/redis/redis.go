@@ -0,0 +1,129 @@
+// Package redis is a Redis backed go_trans.TaskStore. Records are stored
+// as JSON under "go-trans:task:<id>", with every id kept in the set
+// "go-trans:tasks" for List and ids tracked per-status in a set
+// "go-trans:status:<status>" for Claim, so neither needs a full key scan.
+package redis
+
+import (
+	"encoding/json"
+
+	trans "github.com/tangs-drm/go-trans"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/net/context"
+)
+
+const (
+	keyPrefix    = "go-trans:task:"
+	statusPrefix = "go-trans:status:"
+	indexKey     = "go-trans:tasks"
+)
+
+// Store is a TaskStore backed by Redis.
+type Store struct {
+	client *redis.Client
+	ctx    context.Context
+}
+
+// New wraps an existing Redis client as a TaskStore.
+func New(client *redis.Client) *Store {
+	return &Store{client: client, ctx: context.Background()}
+}
+
+func (s *Store) Save(record trans.TaskRecord) error {
+	existing, err := s.Load(record.Id)
+	if err == nil && existing.Status != "" && existing.Status != record.Status {
+		s.client.SRem(s.ctx, statusPrefix+existing.Status, record.Id)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	pipe := s.client.TxPipeline()
+	pipe.Set(s.ctx, keyPrefix+record.Id, data, 0)
+	pipe.SAdd(s.ctx, indexKey, record.Id)
+	pipe.SAdd(s.ctx, statusPrefix+record.Status, record.Id)
+	_, err = pipe.Exec(s.ctx)
+	return err
+}
+
+func (s *Store) Load(id string) (trans.TaskRecord, error) {
+	data, err := s.client.Get(s.ctx, keyPrefix+id).Bytes()
+	if err != nil {
+		return trans.TaskRecord{}, err
+	}
+	var record trans.TaskRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return trans.TaskRecord{}, err
+	}
+	return record, nil
+}
+
+func (s *Store) UpdateStatus(id, status string, attempts int) error {
+	record, err := s.Load(id)
+	if err != nil {
+		return err
+	}
+	record.Status = status
+	record.Attempts = attempts
+	return s.Save(record)
+}
+
+func (s *Store) List(limit, skip int) ([]trans.TaskRecord, int, error) {
+	ids, err := s.client.SMembers(s.ctx, indexKey).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := len(ids)
+	if limit < 0 {
+		skip = 0
+	}
+	if skip > total {
+		skip = total
+	}
+	end := total
+	if limit >= 0 && skip+limit < end {
+		end = skip + limit
+	}
+
+	records := make([]trans.TaskRecord, 0, end-skip)
+	for _, id := range ids[skip:end] {
+		record, err := s.Load(id)
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, total, nil
+}
+
+func (s *Store) Delete(id string) error {
+	record, err := s.Load(id)
+	if err == nil {
+		s.client.SRem(s.ctx, statusPrefix+record.Status, id)
+	}
+	pipe := s.client.TxPipeline()
+	pipe.Del(s.ctx, keyPrefix+id)
+	pipe.SRem(s.ctx, indexKey, id)
+	_, err = pipe.Exec(s.ctx)
+	return err
+}
+
+func (s *Store) Claim(status string) ([]trans.TaskRecord, error) {
+	ids, err := s.client.SMembers(s.ctx, statusPrefix+status).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]trans.TaskRecord, 0, len(ids))
+	for _, id := range ids {
+		record, err := s.Load(id)
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,150 @@
+// Package sqlite is a database/sql backed go_trans.TaskStore.
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	trans "github.com/tangs-drm/go-trans"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS tasks (
+	id         TEXT PRIMARY KEY,
+	input      TEXT NOT NULL,
+	output     TEXT NOT NULL,
+	format     TEXT NOT NULL,
+	args       TEXT NOT NULL,
+	status     TEXT NOT NULL,
+	attempts   INTEGER NOT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+`
+
+// Store is a TaskStore backed by a SQLite database.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens (creating if necessary) a SQLite task store at path.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Save(record trans.TaskRecord) error {
+	args, err := json.Marshal(record.Args)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = s.db.Exec(
+		`INSERT INTO tasks (id, input, output, format, args, status, attempts, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(id) DO UPDATE SET input=excluded.input, output=excluded.output,
+			format=excluded.format, args=excluded.args, status=excluded.status,
+			attempts=excluded.attempts, updated_at=excluded.updated_at`,
+		record.Id, record.Input, record.Output, record.Format, string(args),
+		record.Status, record.Attempts, now, now,
+	)
+	return err
+}
+
+func (s *Store) Load(id string) (trans.TaskRecord, error) {
+	row := s.db.QueryRow(
+		`SELECT id, input, output, format, args, status, attempts, created_at, updated_at
+		 FROM tasks WHERE id = ?`, id)
+	return scanRecord(row)
+}
+
+func (s *Store) UpdateStatus(id, status string, attempts int) error {
+	_, err := s.db.Exec(
+		`UPDATE tasks SET status = ?, attempts = ?, updated_at = ? WHERE id = ?`,
+		status, attempts, time.Now(), id,
+	)
+	return err
+}
+
+func (s *Store) List(limit, skip int) ([]trans.TaskRecord, int, error) {
+	var total int
+	if err := s.db.QueryRow(`SELECT count(*) FROM tasks`).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT id, input, output, format, args, status, attempts, created_at, updated_at
+		FROM tasks ORDER BY created_at ASC`
+	args := []interface{}{}
+	if limit >= 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, skip)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var records []trans.TaskRecord
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		records = append(records, record)
+	}
+	return records, total, rows.Err()
+}
+
+func (s *Store) Delete(id string) error {
+	_, err := s.db.Exec(`DELETE FROM tasks WHERE id = ?`, id)
+	return err
+}
+
+func (s *Store) Claim(status string) ([]trans.TaskRecord, error) {
+	rows, err := s.db.Query(
+		`SELECT id, input, output, format, args, status, attempts, created_at, updated_at
+		 FROM tasks WHERE status = ?`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []trans.TaskRecord
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRecord(row scanner) (trans.TaskRecord, error) {
+	var record trans.TaskRecord
+	var args string
+	if err := row.Scan(&record.Id, &record.Input, &record.Output, &record.Format,
+		&args, &record.Status, &record.Attempts, &record.CreatedAt, &record.UpdatedAt); err != nil {
+		return trans.TaskRecord{}, err
+	}
+	if err := json.Unmarshal([]byte(args), &record.Args); err != nil {
+		return trans.TaskRecord{}, err
+	}
+	return record, nil
+}
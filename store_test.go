@@ -0,0 +1,51 @@
+package go_trans
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoffDoublesUntilCapped(t *testing.T) {
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{5, 32 * time.Second},
+		{6, time.Minute},
+		{10, time.Minute},
+	}
+	for _, c := range cases {
+		if got := backoff(c.attempt); got != c.want {
+			t.Errorf("backoff(%d) = %v, want %v", c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestShouldRetryTryTimesIsTotalAttempts(t *testing.T) {
+	errFailed := errors.New("boom")
+
+	cases := []struct {
+		name     string
+		err      error
+		canceled bool
+		attempts int
+		tryTimes int
+		want     bool
+	}{
+		{"default TryTimes=1 never retries", errFailed, false, 0, 1, false},
+		{"TryTimes=0 never retries", errFailed, false, 0, 0, false},
+		{"TryTimes=2 retries once", errFailed, false, 0, 2, true},
+		{"TryTimes=2 stops after the retry", errFailed, false, 1, 2, false},
+		{"success is never retried", nil, false, 0, 5, false},
+		{"canceled is never retried", errFailed, true, 0, 5, false},
+	}
+	for _, c := range cases {
+		if got := shouldRetry(c.err, c.canceled, c.attempts, c.tryTimes); got != c.want {
+			t.Errorf("%s: shouldRetry(err, %v, %d, %d) = %v, want %v", c.name, c.canceled, c.attempts, c.tryTimes, got, c.want)
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package go_trans
 
 import (
+	"fmt"
+	"github.com/tangs-drm/go-trans/hls"
+	"github.com/tangs-drm/go-trans/hwaccel"
 	"github.com/tangs-drm/go-trans/util"
 	"log"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 type TransPlugin interface {
@@ -25,6 +29,18 @@ type TransPlugin interface {
 	// error: NewError information of the system.
 	Exec(input, output string, args map[string]interface{}) (int, TransMessage, error)
 
+	// ExecSegment transcodes a single HLS/DASH segment of input on demand.
+	//
+	// input: Input file name.
+	// segmentIdx: Index of the segment to produce, as laid out by the
+	//		stream session's keyframe-aligned segment list.
+	// quality: The rung of the quality ladder to encode this segment at.
+	// args: Extra transcoding parameters, same shape as Exec's args.
+	//
+	// []byte: The encoded MPEG-TS segment.
+	// error: NewError information of the system.
+	ExecSegment(input string, segmentIdx int, quality Quality, args map[string]interface{}) ([]byte, error)
+
 	// Cancel the current transcoding task.
 	//
 	// error: error message.
@@ -34,6 +50,40 @@ type TransPlugin interface {
 	Process() (map[string]interface{}, error)
 }
 
+// RangeExecer is an optional TransPlugin extension for plugins that can
+// transcode an arbitrary [start, start+duration) slice of input directly.
+// AddSegmentedTask requires it: its merged splits don't share ExecSegment's
+// keyframe-aligned index space, so it can't fall back to ExecSegment the
+// way watchProgress falls back to polling when a plugin lacks
+// ProgressPipeProvider.
+type RangeExecer interface {
+	// ExecRange transcodes an arbitrary [start, start+duration) slice of
+	// input, given explicitly in seconds rather than a segment index.
+	//
+	// input: Input file name.
+	// start: Start offset of the slice, in seconds.
+	// duration: Length of the slice, in seconds.
+	// args: Extra transcoding parameters, same shape as Exec's args.
+	//
+	// []byte: The encoded media for the slice, suitable for concat demuxing.
+	// error: NewError information of the system.
+	ExecRange(input string, start, duration float64, args map[string]interface{}) ([]byte, error)
+}
+
+// Quality is one rung of a quality ladder: the target height, bitrate and
+// codec a StreamSession should encode its segments at.
+type Quality struct {
+	Height  int
+	Bitrate int
+	Codec   string
+}
+
+// String returns a stable key identifying the quality rung, suitable for
+// use as a map key alongside an input name.
+func (q Quality) String() string {
+	return fmt.Sprintf("%dp-%d-%s", q.Height, q.Bitrate, q.Codec)
+}
+
 const (
 	TransRunning = "running"
 	TransStop    = "stop"
@@ -48,19 +98,42 @@ type TransManage struct {
 	TransPlugin map[string]TransPlugin
 	Tasks       []*Task
 
+	QualityLadders map[string][]Quality
+	StreamSessions map[string]*StreamSession
+
+	Store TaskStore
+
+	// TryTimes is the total number of attempts exec makes at a task before
+	// giving up, including the first one. TryTimes <= 1 means no retry: a
+	// task is attempted exactly once.
 	TryTimes int
 	Status   string
 
-	addSign chan int
-	isLoop  bool
-	lock    *sync.Mutex
+	addSign      chan int
+	isLoop       bool
+	lock         *sync.Mutex
+	attempts     map[string]int
+	canceled     map[string]bool
+	progressSubs map[string][]*progressSub
+
+	encodersOnce sync.Once
+	encoders     []hwaccel.Encoder
 }
 
 // The default number of transcoding threads
 var DefaultMaxRunningNum = 1
 
+// DefaultTryTimes attempts each task exactly once, with no retry.
 var DefaultTryTimes = 1
 
+// DefaultGoalBufferMax is the default number of segments a StreamSession keeps
+// ready ahead of the current playhead before pausing its encoder goroutine.
+var DefaultGoalBufferMax = 5
+
+// DefaultSegmentIdleTimeout is how long a StreamSession waits without a new
+// segment request before it tears down its underlying ffmpeg process.
+var DefaultSegmentIdleTimeout = 2 * time.Minute
+
 // The default trans manager.
 var DefaultTransManager = &TransManage{
 	MaxRunningNum:  DefaultMaxRunningNum,
@@ -70,12 +143,18 @@ var DefaultTransManager = &TransManage{
 	TransPlugin: map[string]TransPlugin{},
 	Tasks:       []*Task{},
 
+	QualityLadders: map[string][]Quality{},
+	StreamSessions: map[string]*StreamSession{},
+
 	TryTimes: DefaultTryTimes,
 	Status:   TransStop,
 
-	addSign: make(chan int, 256),
-	isLoop:  false,
-	lock:    &sync.Mutex{},
+	addSign:      make(chan int, 256),
+	isLoop:       false,
+	lock:         &sync.Mutex{},
+	attempts:     map[string]int{},
+	canceled:     map[string]bool{},
+	progressSubs: map[string][]*progressSub{},
 }
 
 var DefaultFormats = []string{"flv"}
@@ -99,6 +178,19 @@ func RegisterPlugin(format string, transPlugin TransPlugin) error {
 	return DefaultTransManager.RegisterPlugin(format, transPlugin)
 }
 
+// RegisterQualityLadder registers the set of qualities a format can be
+// streamed at through OpenStream, e.g. 240p/480p/720p/1080p/original.
+//
+// format: video format like .flv, .avi.
+// ladder: the qualities selectable for this format, in any order.
+func (tm *TransManage) RegisterQualityLadder(format string, ladder []Quality) {
+	tm.QualityLadders[format] = ladder
+}
+
+func RegisterQualityLadder(format string, ladder []Quality) {
+	DefaultTransManager.RegisterQualityLadder(format, ladder)
+}
+
 // GetFormats return the supported transcoding format
 func (tm *TransManage) GetFormats() []string {
 	return tm.Formats
@@ -118,12 +210,22 @@ func SetMaxRunningNum(num int) {
 	DefaultTransManager.SetMaxRunningNum(num)
 }
 
+// ArgEncoder is the args key used to request a specific encoder, e.g.
+// "auto", "copy", "libx264", "h264_vaapi". See package hwaccel.
+const ArgEncoder = "encoder"
+
 // AddTask add a transcoding task, but just add the transcoding queue at this time,
 // and do not really start transcoding.
 //
 // input: Input filename.
 // output: Output filename.
 func (tm *TransManage) AddTask(input, output string) (Task, error) {
+	return tm.AddTaskWithArgs(input, output, nil)
+}
+
+// AddTaskWithArgs is AddTask with extra transcoding parameters, such as
+// {ArgEncoder: "h264_vaapi"}.
+func (tm *TransManage) AddTaskWithArgs(input, output string, args map[string]interface{}) (Task, error) {
 	tm.lock.Lock()
 	defer tm.lock.Unlock()
 
@@ -146,9 +248,18 @@ func (tm *TransManage) AddTask(input, output string) (Task, error) {
 		Input:  input,
 		Output: output,
 		Plugin: plugin,
+		Args:   args,
+		Status: TASK_QUEUED,
 	}
 
-	// todo. save into database.
+	if tm.Store != nil {
+		if err := tm.Store.Save(TaskRecord{
+			Id: task.Id, Input: input, Output: output, Format: inputExt,
+			Args: args, Status: TASK_QUEUED,
+		}); err != nil {
+			return Task{}, util.NewError("save task: %v", err)
+		}
+	}
 	tm.Tasks = append(tm.Tasks, task)
 
 	tm.addSign <- 1
@@ -157,6 +268,9 @@ func (tm *TransManage) AddTask(input, output string) (Task, error) {
 }
 
 func RunTask() {
+	if err := DefaultTransManager.Resume(); err != nil {
+		log.Printf("TransManage resume: %v", err)
+	}
 	go DefaultTransManager.runTask()
 }
 
@@ -174,7 +288,8 @@ func (tm *TransManage) runTask() {
 		}
 
 		for _, task := range tm.Tasks {
-			if TASK_RUNNING == task.Status {
+			switch task.Status {
+			case TASK_RUNNING, TASK_SUCCESS, TASK_FAILED, TASK_CANCELED:
 				continue
 			}
 			go tm.exec(task)
@@ -183,12 +298,63 @@ func (tm *TransManage) runTask() {
 }
 
 func (tm *TransManage) exec(task *Task) {
-	code, result, err1 := task.Plugin.Exec(task.Input, task.Output, task.Args)
+	tm.lock.Lock()
+	attempts := tm.attempts[task.Id]
+	tm.lock.Unlock()
+
+	task.Status = TASK_RUNNING
+	if tm.Store != nil {
+		tm.Store.UpdateStatus(task.Id, TASK_RUNNING, attempts)
+	}
+
+	code, result, err1, demotions := tm.execWithEncoderFallback(task)
+
+	tm.lock.Lock()
+	canceled := tm.canceled[task.Id]
+	retriable := shouldRetry(err1, canceled, tm.attempts[task.Id], tm.TryTimes)
+	if retriable {
+		tm.attempts[task.Id]++
+	}
+	attempts = tm.attempts[task.Id]
+	tm.lock.Unlock()
+
+	if retriable {
+		task.Status = TASK_RETRYING
+		if tm.Store != nil {
+			tm.Store.UpdateStatus(task.Id, TASK_RETRYING, attempts)
+		}
+		time.AfterFunc(backoff(attempts), func() {
+			// Cancel, or runTask picking the task up again, moves it off
+			// TASK_RETRYING; only a still-pending retry is run here.
+			if task.Status != TASK_RETRYING {
+				return
+			}
+			tm.exec(task)
+		})
+		return
+	}
+
+	finalStatus := TASK_SUCCESS
+	if err1 != nil {
+		finalStatus = TASK_FAILED
+	}
+	if canceled {
+		finalStatus = TASK_CANCELED
+	}
+	task.Status = finalStatus
+	if tm.Store != nil {
+		tm.Store.UpdateStatus(task.Id, finalStatus, attempts)
+	}
+
+	callTask := *task
+	if len(demotions) > 0 {
+		callTask.Args = withDemotions(task.Args, demotions)
+	}
 	call := Call{
 		Code:         code,
 		Error:        ErrorCode[code],
 		ErrorMessage: err1,
-		Task:         *task,
+		Task:         callTask,
 		Message:      result,
 	}
 	err2 := tm.CallBack(call)
@@ -198,6 +364,77 @@ func (tm *TransManage) exec(task *Task) {
 	tm.addSign <- 1
 }
 
+// ArgDemotions is the Call/Task args key holding the []hwaccel.Demotion
+// recorded when execWithEncoderFallback had to fall back off the requested
+// encoder.
+const ArgDemotions = "_encoder_demotions"
+
+// availableEncoders lazily runs hwaccel.Detect once per TransManage and
+// caches the result.
+func (tm *TransManage) availableEncoders() []hwaccel.Encoder {
+	tm.encodersOnce.Do(func() {
+		detected, err := hwaccel.Detect()
+		if err != nil {
+			log.Printf("hwaccel: detect: %v", err)
+			return
+		}
+		tm.encoders = detected
+	})
+	return tm.encoders
+}
+
+// execWithEncoderFallback runs task through the encoder fallback chain
+// resolved from task.Args[ArgEncoder] (or "auto"), trying each encoder in
+// turn until one succeeds, and reports every encoder it had to demote away
+// from.
+func (tm *TransManage) execWithEncoderFallback(task *Task) (int, TransMessage, error, []hwaccel.Demotion) {
+	requested := hwaccel.Auto
+	if task.Args != nil {
+		if v, ok := task.Args[ArgEncoder].(string); ok && v != "" {
+			requested = hwaccel.Encoder(v)
+		}
+	}
+	chain := hwaccel.Resolve(requested, tm.availableEncoders())
+
+	var demotions []hwaccel.Demotion
+	var code int
+	var result TransMessage
+	var err error
+
+	for i, enc := range chain {
+		args := withEncoder(task.Args, enc)
+		code, result, err = task.Plugin.Exec(task.Input, task.Output, args)
+		if err == nil {
+			return code, result, nil, demotions
+		}
+		if i+1 < len(chain) {
+			demotions = append(demotions, hwaccel.Demotion{From: enc, To: chain[i+1], Err: err})
+		}
+	}
+	return code, result, err, demotions
+}
+
+// withEncoder returns a copy of args with ArgEncoder set to enc.
+func withEncoder(args map[string]interface{}, enc hwaccel.Encoder) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range args {
+		out[k] = v
+	}
+	out[ArgEncoder] = string(enc)
+	return out
+}
+
+// withDemotions returns a copy of args with ArgDemotions set to demotions,
+// so Call.Task.Args surfaces the fallback history to callers.
+func withDemotions(args map[string]interface{}, demotions []hwaccel.Demotion) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range args {
+		out[k] = v
+	}
+	out[ArgDemotions] = demotions
+	return out
+}
+
 // ListTask list the transcoding task.
 //
 // limit: Maximum number tasks return when func exec. If limit is less than 0, all of the task data is returned.
@@ -206,17 +443,303 @@ func (tm *TransManage) exec(task *Task) {
 // []Task: Tasks' detail.
 // int: The count of all tasks.
 func (tm *TransManage) ListTask(limit, skip int) ([]Task, int) {
-	return nil, 0
+	if tm.Store == nil {
+		tm.lock.Lock()
+		defer tm.lock.Unlock()
+
+		total := len(tm.Tasks)
+		if limit < 0 {
+			skip = 0
+			limit = total
+		}
+		if skip > total {
+			return []Task{}, total
+		}
+		end := skip + limit
+		if end > total {
+			end = total
+		}
+
+		tasks := make([]Task, 0, end-skip)
+		for _, task := range tm.Tasks[skip:end] {
+			tasks = append(tasks, *task)
+		}
+		return tasks, total
+	}
+
+	records, total, err := tm.Store.List(limit, skip)
+	if err != nil {
+		return nil, 0
+	}
+
+	tasks := make([]Task, 0, len(records))
+	for _, record := range records {
+		tasks = append(tasks, Task{
+			Id:     record.Id,
+			Input:  record.Input,
+			Output: record.Output,
+			Args:   record.Args,
+			Plugin: tm.TransPlugin[record.Format],
+			Status: record.Status,
+		})
+	}
+	return tasks, total
 }
 
+// Cancel stops the running or queued task identified by id and marks it so
+// the retry loop in exec does not requeue it.
 func (tm *TransManage) Cancel(id string) error {
+	tm.lock.Lock()
+	tm.canceled[id] = true
+	attempts := tm.attempts[id]
+	var task *Task
+	for _, t := range tm.Tasks {
+		if t.Id == id {
+			task = t
+			break
+		}
+	}
+	tm.lock.Unlock()
+
+	if task == nil {
+		return util.NewError("task not found: %v", id)
+	}
+	task.Status = TASK_CANCELED
+	if tm.Store != nil {
+		tm.Store.UpdateStatus(id, TASK_CANCELED, attempts)
+	}
+	return task.Plugin.Cancel()
+}
+
+func (tm *TransManage) CallBack(call Call) error {
 	return nil
 }
 
-func (tm *TransManage) Process(id []string) {
+// StreamSession serves HLS segments for one (input, quality) pair,
+// transcoding them on demand instead of up front. A background goroutine
+// keeps a rolling window of GoalBufferMax ready segments ahead of the
+// current playhead and tears down its ffmpeg process after Idle of
+// inactivity.
+type StreamSession struct {
+	TaskId  string
+	Input   string
+	Quality Quality
+
+	Duration   float64
+	Segments   []hls.Segment
+	GoalBuffer int
+	Idle       time.Duration
+
+	plugin TransPlugin
+	args   map[string]interface{}
+
+	manager *TransManage
+	key     string
+
+	lock     sync.Mutex
+	chunks   map[int][]byte
+	failures map[int]int
+	goal     int
+	playhead int
+	notify   chan int
+	idleT    *time.Timer
+	closed   bool
+}
+
+// DefaultMaxSegmentRetries is how many consecutive encode failures
+// advanceLoop tolerates for a single segment before giving up on it and
+// advancing past it, rather than retrying the same segment forever.
+var DefaultMaxSegmentRetries = 3
+
+// newStreamSession probes input, lays out keyframe-aligned segments and
+// starts the background encoder-advance goroutine. key is the
+// tm.StreamSessions entry this session owns, so Close can evict it.
+func newStreamSession(tm *TransManage, key, taskId, input string, quality Quality, plugin TransPlugin, args map[string]interface{}) (*StreamSession, error) {
+	duration, keyframes, err := hls.Probe(input)
+	if err != nil {
+		return nil, util.NewError("hls: probe %v: %v", input, err)
+	}
 
+	ss := &StreamSession{
+		TaskId:     taskId,
+		Input:      input,
+		Quality:    quality,
+		Duration:   duration,
+		Segments:   hls.BuildSegments(keyframes, duration),
+		GoalBuffer: DefaultGoalBufferMax,
+		Idle:       DefaultSegmentIdleTimeout,
+		plugin:     plugin,
+		args:       args,
+		manager:    tm,
+		key:        key,
+		chunks:     map[int][]byte{},
+		failures:   map[int]int{},
+		notify:     make(chan int, 1),
+	}
+	go ss.advanceLoop()
+	return ss, nil
 }
 
-func (tm *TransManage) CallBack(call Call) error {
-	return nil
+// Manifest returns the HLS media playlist for this session.
+func (ss *StreamSession) Manifest() string {
+	return hls.WriteMediaPlaylist(ss.Segments, true)
+}
+
+// RequestSegment returns the bytes for segmentIdx, bumping the playhead and
+// the producer's goal so the background goroutine keeps GoalBufferMax
+// segments ready ahead of it. A seek more than GoalBufferMax segments past
+// the current position restarts the encoder at the new position.
+func (ss *StreamSession) RequestSegment(segmentIdx int) ([]byte, error) {
+	ss.lock.Lock()
+	seek := segmentIdx > ss.playhead+ss.GoalBuffer || segmentIdx < ss.playhead
+	ss.playhead = segmentIdx
+	if segmentIdx+ss.GoalBuffer > ss.goal {
+		ss.goal = segmentIdx + ss.GoalBuffer
+	}
+	ss.pruneLocked()
+	chunk, ready := ss.chunks[segmentIdx]
+	ss.lock.Unlock()
+
+	select {
+	case ss.notify <- segmentIdx:
+	default:
+	}
+
+	if seek {
+		// Jump the encoder to the new position instead of waiting for it to
+		// walk forward segment by segment.
+		if _, err := ss.encodeSegment(segmentIdx); err != nil {
+			return nil, err
+		}
+		ss.lock.Lock()
+		chunk = ss.chunks[segmentIdx]
+		ss.lock.Unlock()
+		return chunk, nil
+	}
+
+	if ready {
+		return chunk, nil
+	}
+	return ss.encodeSegment(segmentIdx)
+}
+
+func (ss *StreamSession) encodeSegment(segmentIdx int) ([]byte, error) {
+	data, err := ss.plugin.ExecSegment(ss.Input, segmentIdx, ss.Quality, ss.args)
+	if err != nil {
+		return nil, err
+	}
+	ss.lock.Lock()
+	ss.chunks[segmentIdx] = data
+	ss.lock.Unlock()
+	return data, nil
+}
+
+// pruneLocked drops segments older than GoalBufferMax behind the playhead.
+// Callers must hold ss.lock.
+func (ss *StreamSession) pruneLocked() {
+	for idx := range ss.chunks {
+		if idx < ss.playhead-ss.GoalBuffer {
+			delete(ss.chunks, idx)
+		}
+	}
+}
+
+// advanceLoop encodes segments up to the current goal, blocking whenever it
+// catches up, and shuts the session down after Idle with no requests. A
+// segment that fails DefaultMaxSegmentRetries times in a row is given up on
+// so one bad keyframe range can't spin the loop forever; RequestSegment can
+// still retry it directly on demand.
+func (ss *StreamSession) advanceLoop() {
+	for {
+		ss.lock.Lock()
+		next := -1
+		for i := ss.playhead; i < len(ss.Segments); i++ {
+			if _, ok := ss.chunks[i]; !ok && i <= ss.goal && ss.failures[i] < DefaultMaxSegmentRetries {
+				next = i
+				break
+			}
+		}
+		closed := ss.closed
+		ss.lock.Unlock()
+
+		if closed {
+			return
+		}
+
+		if next >= 0 {
+			if _, err := ss.encodeSegment(next); err != nil {
+				ss.lock.Lock()
+				ss.failures[next]++
+				attempts := ss.failures[next]
+				ss.lock.Unlock()
+
+				log.Printf("StreamSession %v: encode segment %v: %v (attempt %v/%v)", ss.TaskId, next, err, attempts, DefaultMaxSegmentRetries)
+				if attempts < DefaultMaxSegmentRetries {
+					time.Sleep(backoff(attempts))
+				}
+			}
+			continue
+		}
+
+		select {
+		case <-ss.notify:
+		case <-time.After(ss.Idle):
+			ss.Close()
+			return
+		}
+	}
+}
+
+// Close stops the session's background encoder goroutine and evicts it
+// from its TransManage so the next OpenStream for the same key starts a
+// fresh session instead of handing back a dead one.
+func (ss *StreamSession) Close() {
+	ss.lock.Lock()
+	ss.closed = true
+	ss.lock.Unlock()
+	_ = ss.plugin.Cancel()
+
+	if ss.manager != nil {
+		ss.manager.lock.Lock()
+		if ss.manager.StreamSessions[ss.key] == ss {
+			delete(ss.manager.StreamSessions, ss.key)
+		}
+		ss.manager.lock.Unlock()
+	}
+}
+
+// OpenStream starts (or returns the existing) StreamSession for input at
+// quality, so callers can request /hls/{taskId}/{quality}/index.m3u8 style
+// manifests and segments.
+//
+// taskId: Identifier used to key the session, e.g. the originating task's Id.
+// input: Input file name.
+// quality: Which rung of the registered quality ladder to encode at.
+func (tm *TransManage) OpenStream(taskId, input string, quality Quality, args map[string]interface{}) (*StreamSession, error) {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+
+	key := taskId + ":" + quality.String()
+	if ss, ok := tm.StreamSessions[key]; ok {
+		ss.lock.Lock()
+		closed := ss.closed
+		ss.lock.Unlock()
+		if !closed {
+			return ss, nil
+		}
+		delete(tm.StreamSessions, key)
+	}
+
+	ext := filepath.Ext(input)
+	plugin := tm.TransPlugin[ext]
+	if plugin == nil {
+		return nil, util.NewError("unsupported format: %v", ext)
+	}
+
+	ss, err := newStreamSession(tm, key, taskId, input, quality, plugin, args)
+	if err != nil {
+		return nil, err
+	}
+	tm.StreamSessions[key] = ss
+	return ss, nil
 }